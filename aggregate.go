@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// reservoirSize bounds how many raw values each bucket keeps per key for
+// quantile estimation. Accuracy/memory tradeoff: a reservoir sample gives
+// an unbiased, uniformly-random subset of the values seen, so p50/p95/p99
+// estimates converge as the reservoir fills, but (unlike a t-digest) error
+// is roughly uniform across the distribution rather than concentrated
+// least at the tails. At 200 int64s per key per bucket that's ~1.6KB,
+// which is cheap enough to keep per-bucket rather than maintaining one
+// sketch for the whole window.
+const reservoirSize = 200
+
+// stat accumulates everything needed to answer sum/avg/min/max/count/
+// quantile queries for one key within one bucket.
+type stat struct {
+	Sum    int64   `json:"sum"`
+	Count  int64   `json:"count"`
+	Min    int64   `json:"min"`
+	Max    int64   `json:"max"`
+	Seen   int64   `json:"seen"`   // total values offered to the reservoir
+	Sample []int64 `json:"sample"` // reservoir sample, for quantiles
+}
+
+// newStat returns a zero-value stat ready to accumulate values.
+func newStat() *stat {
+	return &stat{}
+}
+
+// add records a single value into s, updating the reservoir sample per
+// Vitter's Algorithm R so the sample stays a uniform random subset of
+// every value ever added.
+func (s *stat) add(value int64) {
+	if s.Count == 0 || value < s.Min {
+		s.Min = value
+	}
+	if s.Count == 0 || value > s.Max {
+		s.Max = value
+	}
+	s.Sum += value
+	s.Count++
+	s.Seen++
+
+	if len(s.Sample) < reservoirSize {
+		s.Sample = append(s.Sample, value)
+		return
+	}
+	if j := rand.Int63n(s.Seen); j < reservoirSize {
+		s.Sample[j] = value
+	}
+}
+
+// merge folds other into s, combining two stats covering disjoint sets of
+// values (e.g. two different buckets for the same key). Samples are
+// concatenated rather than re-sampled down to reservoirSize: a query
+// merges at most numBuckets() reservoirs, which stays small enough to sort
+// directly, and re-sampling on every query would throw away precision we
+// already paid to collect.
+func (s *stat) merge(other *stat) {
+	if other == nil || other.Count == 0 {
+		return
+	}
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if s.Count == 0 || other.Max > s.Max {
+		s.Max = other.Max
+	}
+	s.Sum += other.Sum
+	s.Count += other.Count
+	s.Seen += other.Seen
+	s.Sample = append(s.Sample, other.Sample...)
+}
+
+// avg returns the mean of the recorded values, or 0 if none were recorded.
+func (s *stat) avg() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Sum) / float64(s.Count)
+}
+
+// quantile returns the q-th quantile (0 <= q <= 1) of s's reservoir
+// sample, using nearest-rank interpolation. Because the sample is a
+// uniform random subset rather than the full value set, this is an
+// approximation whose error shrinks as more values are recorded per key
+// per window.
+func (s *stat) quantile(q float64) int64 {
+	if len(s.Sample) == 0 {
+		return 0
+	}
+
+	sorted := append([]int64(nil), s.Sample...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(q * float64(len(sorted)-1))
+	return sorted[idx]
+}
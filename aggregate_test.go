@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatAggregations(t *testing.T) {
+	s := newStat()
+	for _, v := range []int64{10, 20, 30, 40, 50} {
+		s.add(v)
+	}
+
+	assert.Equal(t, int64(150), s.Sum)
+	assert.Equal(t, int64(5), s.Count)
+	assert.Equal(t, int64(10), s.Min)
+	assert.Equal(t, int64(50), s.Max)
+	assert.Equal(t, 30.0, s.avg())
+	assert.Equal(t, int64(30), s.quantile(0.5))
+	assert.Equal(t, int64(50), s.quantile(1))
+	assert.Equal(t, int64(10), s.quantile(0))
+}
+
+func TestStatMerge(t *testing.T) {
+	a := newStat()
+	a.add(10)
+	a.add(20)
+
+	b := newStat()
+	b.add(5)
+	b.add(100)
+
+	a.merge(b)
+
+	assert.Equal(t, int64(135), a.Sum)
+	assert.Equal(t, int64(4), a.Count)
+	assert.Equal(t, int64(5), a.Min)
+	assert.Equal(t, int64(100), a.Max)
+}
+
+func TestMetricsAggregationsAcrossBuckets(t *testing.T) {
+	clk := clock.NewMock()
+	m := newMetrics(withClock(clk))
+
+	m.record("latency", 10)
+	m.record("latency", 20)
+	clk.Add(time.Minute)
+	m.record("latency", 30)
+
+	agg := m.aggregate("latency")
+	assert.Equal(t, int64(60), agg.Sum)
+	assert.Equal(t, int64(3), agg.Count)
+	assert.Equal(t, int64(10), agg.Min)
+	assert.Equal(t, int64(30), agg.Max)
+	assert.Equal(t, 20.0, agg.avg())
+}
+
+func TestMetricsCustomWindowAndResolution(t *testing.T) {
+	clk := clock.NewMock()
+	m := newMetrics(withClock(clk), withWindow(10*time.Second), withResolution(time.Second))
+
+	m.record("requests", 1)
+	clk.Add(5 * time.Second)
+	m.record("requests", 1)
+
+	assert.Equal(t, int64(2), m.sum("requests"))
+
+	clk.Add(10 * time.Second)
+	assert.Equal(t, int64(0), m.sum("requests"))
+}
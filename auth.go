@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+)
+
+// tokensFileEnv names the environment variable pointing at the JSON file
+// of tokens to load. If unset, auth is disabled and every request is
+// allowed through, preserving this package's original behavior.
+const tokensFileEnv = "SERV_MS_TOKENS_FILE"
+
+// token is one bearer token's configuration: what it's allowed to do and
+// how fast it's allowed to do it.
+type token struct {
+	Value  string   `json:"token"`
+	Scopes []string `json:"scopes"` // "METHOD:/path/pattern", "*" matches one segment
+	Exp    int64    `json:"exp"`    // unix seconds; 0 means no expiry
+	Rate   float64  `json:"rate"`   // refill rate, requests/second
+	Burst  float64  `json:"burst"`  // bucket capacity, requests
+}
+
+// loadTokens reads a JSON array of tokens from path.
+func loadTokens(path string) ([]token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading tokens file: %w", err)
+	}
+
+	var tokens []token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("unmarshaling tokens file: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// authMiddleware requires a valid, scoped, not-rate-limited bearer token
+// on every request it wraps.
+type authMiddleware struct {
+	clk    clock.Clock
+	tokens map[string]token
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// withAuthClock mocks the clock the rate limiter measures refill against.
+func withAuthClock(clk clock.Clock) func(*authMiddleware) {
+	return func(a *authMiddleware) {
+		a.clk = clk
+	}
+}
+
+// newAuthMiddleware builds a middleware that authorizes requests against
+// tokens.
+func newAuthMiddleware(tokens []token, options ...func(*authMiddleware)) *authMiddleware {
+	a := &authMiddleware{
+		tokens:  make(map[string]token, len(tokens)),
+		buckets: make(map[string]*tokenBucket),
+	}
+	for _, t := range tokens {
+		a.tokens[t.Value] = t
+	}
+
+	for _, option := range options {
+		option(a)
+	}
+
+	if a.clk == nil {
+		a.clk = clock.New()
+	}
+
+	return a
+}
+
+// wrap requires a bearer token scoped to method+path on every request
+// before calling next, rate limiting per token.
+func (a *authMiddleware) wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		_, err := a.authorize(value, r.Method, r.URL.Path)
+		var rl *rateLimitError
+		switch {
+		case err == nil:
+			next(w, r)
+		case errors.As(err, &rl):
+			w.Header().Set("Retry-After", strconv.Itoa(int(rl.retryAfter.Seconds()+1)))
+			http.Error(w, "Error: rate limit exceeded", http.StatusTooManyRequests)
+		case errors.Is(err, errForbiddenScope):
+			http.Error(w, "Error: "+err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "Error: "+err.Error(), http.StatusUnauthorized)
+		}
+	}
+}
+
+var (
+	errMissingToken   = errors.New("missing bearer token")
+	errUnknownToken   = errors.New("unknown token")
+	errTokenExpired   = errors.New("token expired")
+	errForbiddenScope = errors.New("token not scoped for this request")
+)
+
+// rateLimitError reports that a token's bucket is empty, plus how long
+// until a retry would succeed.
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string { return "rate limit exceeded" }
+
+// authorize looks up value against a.tokens and checks expiry, scope
+// (against method+path), and rate limit, in that order - the one check
+// shared by both the HTTP middleware and the gRPC interceptors, so the
+// two surfaces can't drift out of lockstep on what a token is allowed to
+// do.
+func (a *authMiddleware) authorize(value, method, path string) (token, error) {
+	if value == "" {
+		return token{}, errMissingToken
+	}
+
+	t, ok := a.tokens[value]
+	if !ok {
+		return token{}, errUnknownToken
+	}
+	if t.Exp != 0 && a.clk.Now().Unix() >= t.Exp {
+		return token{}, errTokenExpired
+	}
+	if !scopeAllows(t.Scopes, method, path) {
+		return token{}, errForbiddenScope
+	}
+	if wait, ok := a.allow(t); !ok {
+		return token{}, &rateLimitError{retryAfter: wait}
+	}
+
+	return t, nil
+}
+
+// allow consumes one request from t's bucket, creating the bucket on
+// first use. It reports whether the request is allowed and, if not, how
+// long the caller should wait before retrying.
+func (a *authMiddleware) allow(t token) (time.Duration, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	b := a.buckets[t.Value]
+	if b == nil {
+		b = &tokenBucket{tokens: t.Burst, last: a.clk.Now()}
+		a.buckets[t.Value] = b
+	}
+
+	return b.take(a.clk.Now(), t.Rate, t.Burst)
+}
+
+// scopeAllows reports whether any of scopes authorizes method+path. A
+// scope is "METHOD:/path/pattern"; the method must match exactly, and the
+// path must have the same number of slash-separated segments as pattern,
+// each matching literally or against a "*" wildcard. This matches
+// segment-by-segment, unlike path.Match's "*", which never crosses a "/"
+// - so a scope meant to cover /metric/{key}/{agg} must spell out both
+// segments (e.g. "GET:/metric/*/*"), and a single "*" only ever grants
+// one path segment's worth of access.
+func scopeAllows(scopes []string, method, path string) bool {
+	reqSegs := strings.Split(strings.Trim(path, "/"), "/")
+	for _, scope := range scopes {
+		scopeMethod, pattern, ok := strings.Cut(scope, ":")
+		if !ok || scopeMethod != method {
+			continue
+		}
+		if segmentsMatch(strings.Split(strings.Trim(pattern, "/"), "/"), reqSegs) {
+			return true
+		}
+	}
+	return false
+}
+
+// segmentsMatch reports whether segs matches pattern segment-by-segment,
+// where a pattern segment of "*" matches any single segment.
+func segmentsMatch(pattern, segs []string) bool {
+	if len(pattern) != len(segs) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != "*" && p != segs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenBucket is a classic token-bucket rate limiter: tokens refill
+// continuously at rate per second up to burst capacity, and each allowed
+// request consumes one.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take reports whether a request may proceed, refilling based on elapsed
+// time since the last call. If denied, it also returns how long until
+// enough tokens will have refilled for a retry to succeed.
+func (b *tokenBucket) take(now time.Time, rate, burst float64) (time.Duration, bool) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+
+	b.tokens += elapsed * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return wait, false
+}
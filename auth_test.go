@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScopeAllows(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		method  string
+		path    string
+		allowed bool
+	}{
+		{"exact match", []string{"GET:/metric/foo/sum"}, "GET", "/metric/foo/sum", true},
+		{"glob wildcard", []string{"POST:/metric/*"}, "POST", "/metric/foo", true},
+		{"wrong method", []string{"GET:/metric/foo/sum"}, "POST", "/metric/foo/sum", false},
+		{"no scopes match", []string{"GET:/metric/bar/sum"}, "GET", "/metric/foo/sum", false},
+		{"multiple scopes, second matches", []string{"GET:/metric/bar/sum", "GET:/metric/foo/sum"}, "GET", "/metric/foo/sum", true},
+		{"two-segment get wildcard", []string{"GET:/metric/*/*"}, "GET", "/metric/foo/sum", true},
+		{"two-segment get wildcard, stream", []string{"GET:/metric/*/*"}, "GET", "/metric/foo/stream", true},
+		{"single wildcard segment does not cross a slash", []string{"GET:/metric/*"}, "GET", "/metric/foo/sum", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, scopeAllows(tt.scopes, tt.method, tt.path))
+		})
+	}
+}
+
+func TestAuthMiddlewareMissingAndUnknownToken(t *testing.T) {
+	a := newAuthMiddleware([]token{{Value: "secret", Scopes: []string{"GET:/metric/foo/sum"}, Rate: 1, Burst: 1}})
+	handler := a.wrap(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/metric/foo/sum", nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req.Header.Set("Authorization", "Bearer wrong")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestAuthMiddlewareExpiry(t *testing.T) {
+	clk := clock.NewMock()
+	a := newAuthMiddleware(
+		[]token{{Value: "secret", Scopes: []string{"GET:/metric/foo/sum"}, Exp: clk.Now().Add(time.Minute).Unix(), Rate: 10, Burst: 10}},
+		withAuthClock(clk),
+	)
+	handler := a.wrap(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/metric/foo/sum", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	clk.Add(2 * time.Minute)
+
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestAuthMiddlewareScopeForbidden(t *testing.T) {
+	a := newAuthMiddleware([]token{{Value: "secret", Scopes: []string{"GET:/metric/foo/sum"}, Rate: 10, Burst: 10}})
+	handler := a.wrap(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/metric/bar/sum", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestAuthMiddlewareRateLimit(t *testing.T) {
+	clk := clock.NewMock()
+	a := newAuthMiddleware(
+		[]token{{Value: "secret", Scopes: []string{"GET:/metric/foo/sum"}, Rate: 1, Burst: 2}},
+		withAuthClock(clk),
+	)
+	handler := a.wrap(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/metric/foo/sum", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	clk.Add(time.Second)
+
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
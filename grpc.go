@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	servmspb "github.com/danMateer/serv-ms/proto"
+)
+
+// metricsGRPCServer implements servmspb.MetricsServiceServer against a
+// shared *metrics instance, so gRPC and HTTP clients see the same data.
+type metricsGRPCServer struct {
+	servmspb.UnimplementedMetricsServiceServer
+
+	m    *metrics
+	auth *authMiddleware // nil means gRPC is unauthenticated
+}
+
+// newMetricsGRPCServer wires up a gRPC server over m. auth may be nil, in
+// which case every RPC is allowed through unauthenticated - callers that
+// lock down the HTTP API via SERV_MS_TOKENS_FILE must pass the same
+// *authMiddleware here too, or gRPC remains an open side door to the same
+// data.
+func newMetricsGRPCServer(m *metrics, auth *authMiddleware) *metricsGRPCServer {
+	return &metricsGRPCServer{m: m, auth: auth}
+}
+
+// listenAndServe starts the gRPC server on addr. It's meant to be run in
+// its own goroutine alongside the HTTP server.
+func (s *metricsGRPCServer) listenAndServe(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.serve(lis)
+}
+
+// serve runs the gRPC server over an already-open listener, factored out
+// of listenAndServe so tests can serve on a random port.
+func (s *metricsGRPCServer) serve(lis net.Listener) error {
+	var opts []grpc.ServerOption
+	if s.auth != nil {
+		opts = append(opts,
+			grpc.UnaryInterceptor(authUnaryInterceptor(s.auth)),
+			grpc.StreamInterceptor(authStreamInterceptor(s.auth)),
+		)
+	}
+
+	srv := grpc.NewServer(opts...)
+	servmspb.RegisterMetricsServiceServer(srv, s)
+
+	log.Printf("grpc: listening on %s", lis.Addr())
+	return srv.Serve(lis)
+}
+
+// authorizeGRPC enforces the same token/scope/rate-limit rules wrap uses
+// for HTTP (via the shared authMiddleware.authorize), against a gRPC
+// call's incoming metadata and full method name. Scopes for gRPC use
+// "RPC" in place of an HTTP method, e.g. "RPC:/servms.MetricsService/*"
+// to grant every RPC, or "RPC:/servms.MetricsService/Sum" for just one.
+func authorizeGRPC(a *authMiddleware, ctx context.Context, fullMethod string) (token, error) {
+	var value string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if auths := md.Get("authorization"); len(auths) > 0 {
+			value = strings.TrimPrefix(auths[0], "Bearer ")
+		}
+	}
+
+	t, err := a.authorize(value, "RPC", fullMethod)
+	if err == nil {
+		return t, nil
+	}
+
+	var rl *rateLimitError
+	switch {
+	case errors.As(err, &rl):
+		return token{}, status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry after %ds", int(rl.retryAfter.Seconds()+1))
+	case errors.Is(err, errForbiddenScope):
+		return token{}, status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return token{}, status.Error(codes.Unauthenticated, err.Error())
+	}
+}
+
+// authUnaryInterceptor enforces authorizeGRPC on every unary RPC.
+func authUnaryInterceptor(a *authMiddleware) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, err := authorizeGRPC(a, ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authStreamInterceptor enforces authorizeGRPC once, up front, on every
+// streaming RPC (RecordStream, Watch) - the check runs before any message
+// is exchanged, same as wrap checks the HTTP request before calling next.
+// The authorized token is attached to the stream's context so a handler
+// that receives many client messages over one call (RecordStream) can
+// re-check the rate limit per message instead of relying on the single
+// up-front check, which would otherwise let one stream push unlimited
+// records past a token's configured rate.
+func authStreamInterceptor(a *authMiddleware) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		t, err := authorizeGRPC(a, ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authedStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), tokenCtxKey{}, t)})
+	}
+}
+
+// tokenCtxKey is the context key authedStream attaches an authorized
+// token under.
+type tokenCtxKey struct{}
+
+// authedStream overrides ServerStream.Context so a handler can recover
+// the token authStreamInterceptor already resolved, without looking it
+// up again.
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context { return s.ctx }
+
+func (s *metricsGRPCServer) Record(ctx context.Context, req *servmspb.RecordRequest) (*servmspb.RecordResponse, error) {
+	s.m.record(req.Key, req.Value)
+	return &servmspb.RecordResponse{Sum: s.m.sum(req.Key)}, nil
+}
+
+func (s *metricsGRPCServer) RecordStream(stream servmspb.MetricsService_RecordStreamServer) error {
+	var ingested int64
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&servmspb.RecordStreamResponse{RecordsIngested: ingested})
+		}
+		if err != nil {
+			return err
+		}
+
+		// authStreamInterceptor only authorizes once, up front, so a
+		// long-lived stream re-checks the rate limit on every message it
+		// receives - otherwise one open RecordStream call could push
+		// unlimited records regardless of the token's configured rate.
+		if s.auth != nil {
+			t, _ := stream.Context().Value(tokenCtxKey{}).(token)
+			if wait, ok := s.auth.allow(t); !ok {
+				return status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry after %ds", int(wait.Seconds()+1))
+			}
+		}
+
+		s.m.record(req.Key, req.Value)
+		ingested++
+	}
+}
+
+func (s *metricsGRPCServer) Sum(ctx context.Context, req *servmspb.SumRequest) (*servmspb.SumResponse, error) {
+	return &servmspb.SumResponse{Sum: s.m.sum(req.Key)}, nil
+}
+
+func (s *metricsGRPCServer) Watch(req *servmspb.WatchRequest, stream servmspb.MetricsService_WatchServer) error {
+	ch, cancel := s.m.subscribe(req.Key)
+	defer cancel()
+
+	if err := stream.Send(&servmspb.WatchResponse{Key: req.Key, Sum: s.m.sum(req.Key)}); err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	for {
+		select {
+		case sum, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(&servmspb.WatchResponse{Key: req.Key, Sum: sum}); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/stretchr/testify/assert"
+
+	servmspb "github.com/danMateer/serv-ms/proto"
+)
+
+// startTestGRPCServer serves s on a random local port and returns a
+// connected client plus a cleanup func.
+func startTestGRPCServer(t *testing.T, s *metricsGRPCServer) servmspb.MetricsServiceClient {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go s.serve(lis)
+	t.Cleanup(func() { lis.Close() })
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return servmspb.NewMetricsServiceClient(conn)
+}
+
+func TestMetricsGRPCServerUnauthenticated(t *testing.T) {
+	a := newAuthMiddleware([]token{{Value: "secret", Scopes: []string{"RPC:/servms.MetricsService/*"}, Rate: 10, Burst: 10}})
+	client := startTestGRPCServer(t, newMetricsGRPCServer(newMetrics(), a))
+
+	_, err := client.Sum(context.Background(), &servmspb.SumRequest{Key: "foo"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestMetricsGRPCServerScopeForbidden(t *testing.T) {
+	a := newAuthMiddleware([]token{{Value: "secret", Scopes: []string{"RPC:/servms.MetricsService/Record"}, Rate: 10, Burst: 10}})
+	client := startTestGRPCServer(t, newMetricsGRPCServer(newMetrics(), a))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	_, err := client.Sum(ctx, &servmspb.SumRequest{Key: "foo"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestMetricsGRPCServerAuthorized(t *testing.T) {
+	a := newAuthMiddleware([]token{{Value: "secret", Scopes: []string{"RPC:/servms.MetricsService/*"}, Rate: 10, Burst: 10}})
+	client := startTestGRPCServer(t, newMetricsGRPCServer(newMetrics(), a))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	resp, err := client.Record(ctx, &servmspb.RecordRequest{Key: "foo", Value: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), resp.Sum)
+}
+
+func TestMetricsGRPCServerStreamUnauthenticated(t *testing.T) {
+	a := newAuthMiddleware([]token{{Value: "secret", Scopes: []string{"RPC:/servms.MetricsService/*"}, Rate: 10, Burst: 10}})
+	client := startTestGRPCServer(t, newMetricsGRPCServer(newMetrics(), a))
+
+	stream, err := client.Watch(context.Background(), &servmspb.WatchRequest{Key: "foo"})
+	assert.NoError(t, err)
+
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestMetricsGRPCServerRecordStreamRateLimited(t *testing.T) {
+	a := newAuthMiddleware([]token{{Value: "secret", Scopes: []string{"RPC:/servms.MetricsService/*"}, Rate: 1, Burst: 1}})
+	client := startTestGRPCServer(t, newMetricsGRPCServer(newMetrics(), a))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	stream, err := client.RecordStream(ctx)
+	assert.NoError(t, err)
+
+	// The burst of 1 is spent opening the stream, so the first message
+	// sent over it should already be rate limited.
+	assert.NoError(t, stream.Send(&servmspb.RecordRequest{Key: "foo", Value: 1}))
+	assert.NoError(t, stream.Send(&servmspb.RecordRequest{Key: "foo", Value: 1}))
+
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestMetricsGRPCServerNoAuthConfigured(t *testing.T) {
+	client := startTestGRPCServer(t, newMetricsGRPCServer(newMetrics(), nil))
+
+	resp, err := client.Record(context.Background(), &servmspb.RecordRequest{Key: "foo", Value: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), resp.Sum)
+}
@@ -3,10 +3,15 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"net/http"
+	"os"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/andres-erbsen/clock"
@@ -17,16 +22,41 @@ const value = "value"
 
 func main() {
 	m := newMetrics()
-	http.HandleFunc("/metric/", handle(m))
+
+	metricHandler := handle(m)
+	metricsHandler := handleMetrics(m)
+
+	var auth *authMiddleware
+	if path := os.Getenv(tokensFileEnv); path != "" {
+		tokens, err := loadTokens(path)
+		if err != nil {
+			log.Fatalf("loading %s: %v", tokensFileEnv, err)
+		}
+		auth = newAuthMiddleware(tokens)
+		metricHandler = auth.wrap(metricHandler)
+		metricsHandler = auth.wrap(metricsHandler)
+	}
+
+	http.HandleFunc("/metric/", metricHandler)
+	http.HandleFunc("/metrics", metricsHandler)
+
+	grpcServer := newMetricsGRPCServer(m, auth)
+	go func() {
+		log.Fatal(grpcServer.listenAndServe(":9090"))
+	}()
+
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
 
 func handle(m *metrics) func(w http.ResponseWriter, r *http.Request) {
+	stream := handleStream(m)
 	return func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
+		switch {
+		case r.Method == http.MethodGet && streamURLPattern.MatchString(r.URL.Path):
+			stream(w, r)
+		case r.Method == http.MethodGet:
 			handleGet(m, w, r)
-		case http.MethodPost:
+		case r.Method == http.MethodPost:
 			handlePost(m, w, r)
 		default:
 			w.Header().Set("Allow", fmt.Sprintf("%s, %s", http.MethodGet, http.MethodPost))
@@ -35,6 +65,21 @@ func handle(m *metrics) func(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// aggregations maps the GET suffix ("/metric/{key}/{agg}") to the
+// function that computes it from a key's merged stat.
+var aggregations = map[string]func(s *stat) float64{
+	"sum":   func(s *stat) float64 { return float64(s.Sum) },
+	"avg":   func(s *stat) float64 { return s.avg() },
+	"min":   func(s *stat) float64 { return float64(s.Min) },
+	"max":   func(s *stat) float64 { return float64(s.Max) },
+	"count": func(s *stat) float64 { return float64(s.Count) },
+	"p50":   func(s *stat) float64 { return float64(s.quantile(0.50)) },
+	"p95":   func(s *stat) float64 { return float64(s.quantile(0.95)) },
+	"p99":   func(s *stat) float64 { return float64(s.quantile(0.99)) },
+}
+
+var getURLPattern = regexp.MustCompile(`^/metric/(\w+)/(\w+)$`)
+
 func handleGet(m *metrics, w http.ResponseWriter, r *http.Request) {
 	// Enforce content-type
 	if r.Header.Get("content-type") != contentType {
@@ -43,23 +88,22 @@ func handleGet(m *metrics, w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Enforce url schema
-	rx, _ := regexp.Compile(`^/metric/(\w+)/sum$`)
-	if !rx.MatchString(r.URL.Path) {
-		http.Error(w, "Error: legal GET urls look like '/metric/{key}/sum'", http.StatusNotFound)
+	match := getURLPattern.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		http.Error(w, "Error: legal GET urls look like '/metric/{key}/{sum,avg,min,max,count,p50,p95,p99}'", http.StatusNotFound)
 		return
 	}
+	key, agg := match[1], match[2]
 
-	// Get key
-	match := rx.FindStringSubmatch(r.URL.Path)
-	if match == nil || len(match) < 2 {
+	aggFunc, ok := aggregations[agg]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Error: unknown aggregation %q", agg), http.StatusNotFound)
 		return
 	}
-	key := match[1]
 
-	// Return sum
-	val := m.sum(key)
+	val := aggFunc(m.aggregate(key))
 	encoder := json.NewEncoder(w)
-	encoder.Encode(map[string]int64{
+	encoder.Encode(map[string]float64{
 		value: val,
 	})
 }
@@ -104,17 +148,201 @@ func handlePost(m *metrics, w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "{}")
 }
 
-// We'll store incoming values in per-minute maps.
-// Each per-minute "bucket" is a map of keys to running counts.
-// Those running counts are the values seen so far for a given minute.
-// In practice the count should only be "running" for the current minute.
+var streamURLPattern = regexp.MustCompile(`^/metric/(\w+)/stream$`)
+
+// handleStream serves a key's sliding-window sum as Server-Sent Events,
+// pushing a new event every time record() is called for that key.
+func handleStream(m *metrics) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		match := streamURLPattern.FindStringSubmatch(r.URL.Path)
+		if match == nil {
+			http.Error(w, "Error: legal stream urls look like '/metric/{key}/stream'", http.StatusNotFound)
+			return
+		}
+		key := match[1]
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Error: streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		ch, cancel := m.subscribe(key)
+		defer cancel()
+
+		w.Header().Set("content-type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		fmt.Fprintf(w, "data: %d\n\n", m.sum(key))
+		flusher.Flush()
+
+		for {
+			select {
+			case sum, ok := <-ch:
+				if !ok {
+					return
+				}
+				fmt.Fprintf(w, "data: %d\n\n", sum)
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// handleMetrics serves the sliding-hour snapshot of all recorded keys to
+// scrapers, picking a wire format via content negotiation so Prometheus,
+// Telegraf (InfluxDB line protocol), or anything that just wants JSON can
+// all scrape the same endpoint.
+func handleMetrics(m *metrics) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		exp := exporterFor(r.Header.Get("Accept"))
+		w.Header().Set("content-type", exp.contentType())
+		exp.export(w, m.snapshot())
+	}
+}
+
+// exporter renders a metrics snapshot in a particular wire format.
+type exporter interface {
+	contentType() string
+	export(w http.ResponseWriter, snap map[string]int64)
+}
+
+// exporterFor picks an exporter based on the request's Accept header,
+// defaulting to Prometheus text exposition format since that's the
+// primary consumer this endpoint was built for.
+func exporterFor(accept string) exporter {
+	switch {
+	case strings.Contains(accept, "influx"):
+		return influxExporter{}
+	case strings.Contains(accept, "json"):
+		return jsonExporter{}
+	default:
+		return prometheusExporter{}
+	}
+}
+
+// prometheusExporter renders a snapshot in Prometheus text exposition
+// format: https://prometheus.io/docs/instrumenting/exposition_formats/
+type prometheusExporter struct{}
+
+func (prometheusExporter) contentType() string {
+	return "text/plain; version=0.0.4"
+}
+
+func (prometheusExporter) export(w http.ResponseWriter, snap map[string]int64) {
+	fmt.Fprintln(w, "# HELP serv_ms_sum Sliding one-hour sum of recorded values for a key.")
+	fmt.Fprintln(w, "# TYPE serv_ms_sum gauge")
+	for _, key := range sortedKeys(snap) {
+		fmt.Fprintf(w, "serv_ms_sum{key=%q} %d\n", key, snap[key])
+	}
+}
+
+// influxExporter renders a snapshot in InfluxDB line protocol.
+type influxExporter struct{}
+
+func (influxExporter) contentType() string {
+	return "text/plain"
+}
+
+func (influxExporter) export(w http.ResponseWriter, snap map[string]int64) {
+	for _, key := range sortedKeys(snap) {
+		fmt.Fprintf(w, "serv_ms_sum,key=%s value=%di\n", key, snap[key])
+	}
+}
+
+// jsonExporter renders a snapshot as a flat JSON object of key to sum.
+type jsonExporter struct{}
+
+func (jsonExporter) contentType() string {
+	return contentType
+}
+
+func (jsonExporter) export(w http.ResponseWriter, snap map[string]int64) {
+	json.NewEncoder(w).Encode(snap)
+}
+
+// sortedKeys returns the keys of snap in sorted order, so exported output
+// is stable across scrapes.
+func sortedKeys(snap map[string]int64) []string {
+	keys := make([]string, 0, len(snap))
+	for key := range snap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// numShards is the number of independent locks the bucket map is split
+// across. A single global mutex serializes every writer regardless of
+// which key they're touching; sharding by key hash lets writers for
+// different keys proceed concurrently, which is where the contention
+// under TestMetricsConcurrent-style load actually comes from. It's a
+// power of two so shardFor can use a mask instead of a modulo.
+const numShards = 32
+
+// metricsShard holds the bucket map for the slice of keys that hash to
+// it, guarded by its own mutex. This is sharded locking, not lock-free:
+// each shard still serializes record()/aggregate() for the keys that hash
+// to it behind a plain sync.Mutex. A true lock-free design (atomic
+// counters in a fixed ring buffer) doesn't extend cleanly to the richer
+// per-bucket stat this package keeps (min/max/count/quantile sample), so
+// sharding the lock is the tradeoff made here instead.
+type metricsShard struct {
+	mu      sync.Mutex
+	minutes map[int64]map[string]*stat
+}
+
+func newMetricsShard() *metricsShard {
+	return &metricsShard{minutes: make(map[int64]map[string]*stat)}
+}
+
+// We'll store incoming values in per-bucket maps, one bucket per
+// resolution-sized slice of time. Each bucket is a map of keys to the
+// *stat accumulated for that key during that slice, sharded across
+// numShards independently-locked metricsShards by key hash. We sum (or
+// otherwise aggregate) across every bucket within the window to implement
+// a sliding-window view over the most recent window/resolution buckets.
 type metrics struct {
-	sync.Mutex // guard minutes
+	clk        clock.Clock
+	window     time.Duration
+	resolution time.Duration
+	shards     [numShards]*metricsShard
 
-	clk     clock.Clock
-	minutes map[int64]map[string]int64
+	store             store
+	checkpointCounter int64 // atomic; counts record() calls across all shards
+
+	subsMu sync.Mutex
+	subs   map[string][]chan int64
 }
 
+// checkpointEvery is how many record() calls accumulate, across every
+// shard, before a metrics backed by a store snapshots its bucket map and
+// prunes the WAL.
+const checkpointEvery = 100
+
+// defaultWindow and defaultResolution reproduce this package's original
+// behavior: a sliding hour of one-minute buckets.
+const (
+	defaultWindow     = 60 * time.Minute
+	defaultResolution = time.Minute
+)
+
 // withClock and the clock package help us mock time for testing.
 func withClock(clk clock.Clock) func(*metrics) {
 	return func(m *metrics) {
@@ -122,11 +350,39 @@ func withClock(clk clock.Clock) func(*metrics) {
 	}
 }
 
+// withStore persists every record() call to s's write-ahead log and
+// replays it back on construction, so a restart doesn't lose the last
+// hour of data.
+func withStore(s store) func(*metrics) {
+	return func(m *metrics) {
+		m.store = s
+	}
+}
+
+// withWindow sets how far back sliding-window aggregations look. It must
+// be evenly divisible by the resolution.
+func withWindow(window time.Duration) func(*metrics) {
+	return func(m *metrics) {
+		m.window = window
+	}
+}
+
+// withResolution sets the size of each bucket. Smaller buckets give
+// finer-grained recency at the cost of more buckets to scan per query.
+func withResolution(resolution time.Duration) func(*metrics) {
+	return func(m *metrics) {
+		m.resolution = resolution
+	}
+}
+
 // newMetrics returns a new metrics struct.
 // This helps keep data independent between tests.
 func newMetrics(options ...func(*metrics)) *metrics {
 	m := &metrics{}
-	m.minutes = make(map[int64]map[string]int64)
+	for i := range m.shards {
+		m.shards[i] = newMetricsShard()
+	}
+	m.subs = make(map[string][]chan int64)
 
 	for _, option := range options {
 		option(m)
@@ -135,46 +391,189 @@ func newMetrics(options ...func(*metrics)) *metrics {
 	if m.clk == nil {
 		m.clk = clock.New()
 	}
+	if m.window == 0 {
+		m.window = defaultWindow
+	}
+	if m.resolution == 0 {
+		m.resolution = defaultResolution
+	}
+
+	if m.store != nil {
+		minutes, err := m.store.load()
+		if err != nil {
+			log.Printf("metrics: replaying store: %v", err)
+		} else {
+			m.loadIntoShards(minutes)
+		}
+	}
 
 	return m
 }
 
-// sum recorded values for a given key across the last hour.
+// shardFor returns the shard key hashes to.
+func (m *metrics) shardFor(key string) *metricsShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return m.shards[h.Sum32()&(numShards-1)]
+}
+
+// loadIntoShards distributes a combined bucket map (as returned by
+// store.load) across shards by key hash. Called only during
+// construction, before any concurrent access, so it needs no locking.
+func (m *metrics) loadIntoShards(minutes map[int64]map[string]*stat) {
+	for bucket, keys := range minutes {
+		for key, s := range keys {
+			sh := m.shardFor(key)
+			if sh.minutes[bucket] == nil {
+				sh.minutes[bucket] = make(map[string]*stat)
+			}
+			sh.minutes[bucket][key] = s
+		}
+	}
+}
+
+// numBuckets is how many resolution-sized buckets make up the window.
+func (m *metrics) numBuckets() int {
+	return int(m.window / m.resolution)
+}
+
+// bucket returns the bucket index t falls into.
+func (m *metrics) bucket(t time.Time) int64 {
+	return t.Unix() / int64(m.resolution.Seconds())
+}
+
+// sum recorded values for a given key across the window.
 func (m *metrics) sum(key string) int64 {
-	m.Lock()
-	defer m.Unlock()
+	return m.aggregate(key).Sum
+}
+
+// snapshot returns the sliding-window sum for every key that has been
+// recorded within the window, so callers that need every key (e.g. the
+// /metrics scrape endpoint) don't have to know the key set up front.
+func (m *metrics) snapshot() map[string]int64 {
+	sums := make(map[string]int64)
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+		for key, s := range sh.mergeWindowLocked(m) {
+			sums[key] = s.Sum
+		}
+		sh.mu.Unlock()
+	}
+	return sums
+}
 
-	nowBucket := minutes(m.clk.Now())
+// mergeWindowLocked merges every key's per-bucket stats within m's window
+// for this shard. sh must already be locked.
+func (sh *metricsShard) mergeWindowLocked(m *metrics) map[string]*stat {
+	nowBucket := m.bucket(m.clk.Now())
 
-	sum := int64(0)
-	for bucketOffset := 0; bucketOffset < 60; bucketOffset++ {
-		bucket := m.minutes[nowBucket-int64(bucketOffset)]
-		if bucket != nil {
-			sum += bucket[key]
+	merged := make(map[string]*stat)
+	for bucketOffset := 0; bucketOffset < m.numBuckets(); bucketOffset++ {
+		bucket := sh.minutes[nowBucket-int64(bucketOffset)]
+		for key, s := range bucket {
+			if merged[key] == nil {
+				merged[key] = newStat()
+			}
+			merged[key].merge(s)
 		}
 	}
 
-	return sum
+	return merged
+}
+
+// aggregate merges the per-bucket stats for key across the window. Only
+// key's shard is locked, so concurrent aggregate/record calls for keys in
+// other shards aren't blocked.
+func (m *metrics) aggregate(key string) *stat {
+	sh := m.shardFor(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	nowBucket := m.bucket(m.clk.Now())
+
+	merged := newStat()
+	for bucketOffset := 0; bucketOffset < m.numBuckets(); bucketOffset++ {
+		bucket := sh.minutes[nowBucket-int64(bucketOffset)]
+		if s := bucket[key]; s != nil {
+			merged.merge(s)
+		}
+	}
+
+	return merged
 }
 
 // record a key/value.
 func (m *metrics) record(key string, value int64) int64 {
-	m.Lock()
-	defer m.Unlock()
+	sh := m.shardFor(key)
+	bucket := m.bucket(m.clk.Now())
 
-	bucket := minutes(m.clk.Now())
+	sh.mu.Lock()
+	if sh.minutes[bucket] == nil {
+		sh.minutes[bucket] = make(map[string]*stat)
+	}
+	if sh.minutes[bucket][key] == nil {
+		sh.minutes[bucket][key] = newStat()
+	}
+	sh.minutes[bucket][key].add(value)
+	sum := sh.minutes[bucket][key].Sum
+	sh.mu.Unlock()
 
-	if m.minutes[bucket] == nil {
-		m.minutes[bucket] = make(map[string]int64)
+	if m.store != nil {
+		if err := m.store.append(walEntry{Bucket: bucket, Key: key, Value: value}); err != nil {
+			log.Printf("metrics: appending to store: %v", err)
+		}
+		m.maybeCheckpoint(bucket)
 	}
 
-	m.minutes[bucket][key] += value
+	m.publish(key)
+
+	return sum
+}
+
+// maybeCheckpoint snapshots the combined bucket map and prunes stale WAL
+// entries every checkpointEvery record() calls, bounding the store's
+// on-disk size.
+func (m *metrics) maybeCheckpoint(nowBucket int64) {
+	if atomic.AddInt64(&m.checkpointCounter, 1)%checkpointEvery != 0 {
+		return
+	}
 
-	return m.minutes[bucket][key]
+	if err := m.store.save(m.snapshotForStore); err != nil {
+		log.Printf("metrics: saving snapshot: %v", err)
+		return
+	}
+	if err := m.store.prune(nowBucket - int64(m.numBuckets()) + 1); err != nil {
+		log.Printf("metrics: pruning store: %v", err)
+	}
 }
 
-// minutes returns a minute bucket.
-// We'll sum across 60 minute buckets to implement a sliding hour window.
-func minutes(t time.Time) int64 {
-	return t.Unix() / 60
+// snapshotForStore combines every shard's bucket map into one, locking
+// shards one at a time rather than all at once. The result is an
+// approximation of a single instant in time - a write to shard B can land
+// after shard A was already copied - which is fine for a periodic,
+// best-effort checkpoint but would not be for a strongly-consistent
+// backup. Each *stat is copied by value, but stat.Sample is a slice that
+// add() mutates in place once the reservoir fills, so it must be
+// deep-copied too or a later record() would rewrite entries inside what's
+// supposed to be a frozen snapshot already queued for json.Marshal.
+func (m *metrics) snapshotForStore() map[int64]map[string]*stat {
+	combined := make(map[int64]map[string]*stat)
+
+	for _, sh := range m.shards {
+		sh.mu.Lock()
+		for bucket, keys := range sh.minutes {
+			if combined[bucket] == nil {
+				combined[bucket] = make(map[string]*stat)
+			}
+			for key, s := range keys {
+				cp := *s
+				cp.Sample = append([]int64(nil), s.Sample...)
+				combined[bucket][key] = &cp
+			}
+		}
+		sh.mu.Unlock()
+	}
+
+	return combined
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// BenchmarkMetricsRecord measures record() throughput under increasing
+// concurrency, writing to a fixed pool of keys so the sharded lock
+// actually gets exercised across goroutines (a single hot key would
+// serialize on one shard regardless of numShards). This replaces the
+// single-mutex implementation's equivalent benchmark; see git history for
+// the pre-sharding numbers this was meant to beat.
+func BenchmarkMetricsRecord(b *testing.B) {
+	for _, goroutines := range []int{1, 8, 64, 512} {
+		b.Run(fmt.Sprintf("goroutines=%d", goroutines), func(b *testing.B) {
+			m := newMetrics()
+			keys := benchKeys(numShards * 4)
+
+			// SetParallelism scales relative to GOMAXPROCS rather than
+			// setting an absolute goroutine count, but it's the standard
+			// way to vary concurrency in a Go benchmark.
+			b.SetParallelism(goroutines)
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					m.record(keys[i%len(keys)], 1)
+					i++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkMetricsMixedReadWrite measures throughput with a 90/10
+// read/write mix (sum is far more common than record in a typical
+// scrape-heavy deployment) and a 50/50 mix, at increasing concurrency.
+func BenchmarkMetricsMixedReadWrite(b *testing.B) {
+	ratios := []struct {
+		name        string
+		writeChance float64
+	}{
+		{"90read_10write", 0.10},
+		{"50read_50write", 0.50},
+	}
+
+	for _, goroutines := range []int{1, 8, 64, 512} {
+		for _, ratio := range ratios {
+			b.Run(fmt.Sprintf("goroutines=%d/%s", goroutines, ratio.name), func(b *testing.B) {
+				m := newMetrics()
+				keys := benchKeys(numShards * 4)
+				for _, key := range keys {
+					m.record(key, 1)
+				}
+
+				b.SetParallelism(goroutines)
+				b.RunParallel(func(pb *testing.PB) {
+					rnd := rand.New(rand.NewSource(1))
+					i := 0
+					for pb.Next() {
+						key := keys[i%len(keys)]
+						if rnd.Float64() < ratio.writeChance {
+							m.record(key, 1)
+						} else {
+							m.sum(key)
+						}
+						i++
+					}
+				})
+			})
+		}
+	}
+}
+
+func benchKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("bench-key-%d", i)
+	}
+	return keys
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -257,6 +260,132 @@ func TestMetricsConcurrent(t *testing.T) {
 	assert.Equal(t, int64(numRoutines), m.sum(key))
 }
 
+func TestMetricsConcurrentManyKeys(t *testing.T) {
+	clk := clock.NewMock()
+	m := newMetrics(withClock(clk))
+
+	numKeys := 50
+	numRoutinesPerKey := 20
+
+	var wg sync.WaitGroup
+	for i := 0; i < numKeys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		for j := 0; j < numRoutinesPerKey; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.record(key, 1)
+			}()
+		}
+	}
+	wg.Wait()
+
+	for i := 0; i < numKeys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		assert.Equal(t, int64(numRoutinesPerKey), m.sum(key))
+	}
+}
+
+func TestGetAggregations(t *testing.T) {
+	clk := clock.NewMock()
+	m := newMetrics(withClock(clk))
+	m.record("latency", 10)
+	m.record("latency", 20)
+	m.record("latency", 30)
+
+	server := httptest.NewServer(http.HandlerFunc(handle(m)))
+	defer server.Close()
+
+	tests := []struct {
+		agg  string
+		want float64
+	}{
+		{"sum", 60},
+		{"avg", 20},
+		{"min", 10},
+		{"max", 30},
+		{"count", 3},
+		{"p50", 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.agg, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, server.URL+"/metric/latency/"+tt.agg, nil)
+			req.Header.Set("content-type", contentType)
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			decoder := json.NewDecoder(resp.Body)
+			jsonMap := make(map[string]float64)
+			assert.NoError(t, decoder.Decode(&jsonMap))
+			assert.Equal(t, tt.want, jsonMap[value])
+		})
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/metric/latency/p999", nil)
+	req.Header.Set("content-type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHandleMetricsPrometheus(t *testing.T) {
+	clk := clock.NewMock()
+	m := newMetrics(withClock(clk))
+	m.record("foo", 10)
+	m.record("bar", 5)
+
+	server := httptest.NewServer(http.HandlerFunc(handleMetrics(m)))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Accept", "text/plain")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "# TYPE serv_ms_sum gauge")
+	assert.Contains(t, string(body), `serv_ms_sum{key="bar"} 5`)
+	assert.Contains(t, string(body), `serv_ms_sum{key="foo"} 10`)
+}
+
+func TestHandleMetricsJSON(t *testing.T) {
+	clk := clock.NewMock()
+	m := newMetrics(withClock(clk))
+	m.record("foo", 10)
+
+	server := httptest.NewServer(http.HandlerFunc(handleMetrics(m)))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	decoder := json.NewDecoder(resp.Body)
+	snap := make(map[string]int64)
+	assert.NoError(t, decoder.Decode(&snap))
+	assert.Equal(t, int64(10), snap["foo"])
+}
+
+func TestMetricsSnapshot(t *testing.T) {
+	clk := clock.NewMock()
+	m := newMetrics(withClock(clk))
+
+	m.record("foo", 10)
+	m.record("bar", 15)
+	clk.Add(90 * time.Minute)
+	m.record("bar", 3)
+
+	snap := m.snapshot()
+	assert.Equal(t, int64(0), snap["foo"])
+	assert.Equal(t, int64(3), snap["bar"])
+}
+
 func gosched() {
 	time.Sleep(time.Millisecond)
 }
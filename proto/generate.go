@@ -0,0 +1,8 @@
+// Package servmspb holds the generated gRPC bindings for MetricsService.
+// Regenerate with protoc and the Go plugins on PATH:
+//
+//	go install google.golang.org/protobuf/cmd/protoc-gen-go@latest
+//	go install google.golang.org/grpc/cmd/protoc-gen-go-grpc@latest
+//
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metrics.proto
+package servmspb
@@ -0,0 +1,68 @@
+package main
+
+// subscriberBuffer bounds how many pending sums a watcher can fall behind
+// by before we start dropping. Keeping it small and dropping the oldest
+// update (rather than blocking the writer or growing unbounded) means a
+// slow subscriber can never stall record().
+const subscriberBuffer = 8
+
+// subscribe registers a channel that receives key's current sliding-window
+// sum every time record() is called for key. The returned cancel func must
+// be called to stop the subscription and release the channel.
+func (m *metrics) subscribe(key string) (ch <-chan int64, cancel func()) {
+	m.subsMu.Lock()
+	defer m.subsMu.Unlock()
+
+	c := make(chan int64, subscriberBuffer)
+	m.subs[key] = append(m.subs[key], c)
+
+	return c, func() { m.unsubscribe(key, c) }
+}
+
+func (m *metrics) unsubscribe(key string, target chan int64) {
+	m.subsMu.Lock()
+	defer m.subsMu.Unlock()
+
+	subs := m.subs[key]
+	for i, c := range subs {
+		if c == target {
+			m.subs[key] = append(subs[:i], subs[i+1:]...)
+			close(c)
+			break
+		}
+	}
+}
+
+// publish pushes key's current sum to every subscriber, dropping the
+// oldest buffered update for any subscriber that isn't keeping up rather
+// than blocking the caller (record, which by this point has already
+// released key's shard lock). The sends happen while holding subsMu, the
+// same lock unsubscribe closes channels under, so a channel can never be
+// closed out from under a send in progress - copying the subscriber
+// slice and unlocking before sending would let a concurrent unsubscribe
+// close(c) in the gap and panic this goroutine on c <- sum.
+func (m *metrics) publish(key string) {
+	m.subsMu.Lock()
+	defer m.subsMu.Unlock()
+
+	subs := m.subs[key]
+	if len(subs) == 0 {
+		return
+	}
+
+	sum := m.aggregate(key).Sum
+	for _, c := range subs {
+		select {
+		case c <- sum:
+		default:
+			select {
+			case <-c:
+			default:
+			}
+			select {
+			case c <- sum:
+			default:
+			}
+		}
+	}
+}
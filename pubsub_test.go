@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribePublish(t *testing.T) {
+	m := newMetrics()
+
+	ch, cancel := m.subscribe("foo")
+	defer cancel()
+
+	m.record("foo", 5)
+	assert.Equal(t, int64(5), <-ch)
+
+	m.record("foo", 3)
+	assert.Equal(t, int64(8), <-ch)
+}
+
+func TestSubscribeDropsOldestWhenSlow(t *testing.T) {
+	m := newMetrics()
+
+	ch, cancel := m.subscribe("foo")
+	defer cancel()
+
+	for i := 0; i < subscriberBuffer+2; i++ {
+		m.record("foo", 1)
+	}
+
+	// The channel should never have blocked record(), and should hold
+	// the most recent update.
+	var last int64
+	for len(ch) > 0 {
+		last = <-ch
+	}
+	assert.Equal(t, int64(subscriberBuffer+2), last)
+}
+
+func TestUnsubscribeClosesChannel(t *testing.T) {
+	m := newMetrics()
+
+	ch, cancel := m.subscribe("foo")
+	cancel()
+
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+// TestConcurrentUnsubscribeDuringRecord cancels a subscription on one
+// goroutine while record() keeps firing for the same key on another. If
+// publish ever sends on a channel after unsubscribe has closed it, this
+// panics with "send on closed channel" instead of completing.
+func TestConcurrentUnsubscribeDuringRecord(t *testing.T) {
+	m := newMetrics()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		_, cancel := m.subscribe("foo")
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				m.record("foo", 1)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			cancel()
+		}()
+		wg.Wait()
+	}
+}
+
+func TestHandleStream(t *testing.T) {
+	m := newMetrics()
+
+	server := httptest.NewServer(http.HandlerFunc(handleStream(m)))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/metric/foo/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, "text/event-stream", resp.Header.Get("content-type"))
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "data: 0\n", line)
+
+	go m.record("foo", 9)
+
+	for {
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		assert.Equal(t, "data: 9\n", line)
+		break
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fsyncPolicy controls how aggressively a fileStore flushes the WAL to
+// disk. Fsyncing on every write is the safest but slowest option; callers
+// that can tolerate losing a handful of the most recent records on a crash
+// can trade durability for throughput.
+type fsyncPolicy int
+
+const (
+	// fsyncAlways fsyncs the WAL after every append.
+	fsyncAlways fsyncPolicy = iota
+	// fsyncInterval fsyncs after every fsyncEvery appends.
+	fsyncInterval
+	// fsyncNever leaves fsyncing to the OS's normal write-back policy.
+	fsyncNever
+)
+
+// fsyncEvery is how many WAL appends accumulate between fsyncs under the
+// fsyncInterval policy.
+const fsyncEvery = 100
+
+// walEntry is a single write-ahead log record: one call to metrics.record.
+type walEntry struct {
+	Bucket int64  `json:"bucket"`
+	Key    string `json:"key"`
+	Value  int64  `json:"value"`
+}
+
+// store persists a metrics bucket map across restarts. record() calls are
+// appended to a write-ahead log as they happen; save() periodically
+// checkpoints the full bucket map so the log doesn't grow without bound,
+// and prune() drops WAL entries for buckets that have aged out of the
+// 60-minute window regardless of whether a checkpoint has run. save()
+// takes a snapshot-producing func rather than an already-built map so the
+// implementation can build the snapshot and truncate the WAL as one
+// critical section - otherwise a record() appended between the snapshot
+// being built and the WAL being truncated would be wiped from both.
+type store interface {
+	append(entry walEntry) error
+	load() (map[int64]map[string]*stat, error)
+	save(buildSnapshot func() map[int64]map[string]*stat) error
+	prune(oldestBucket int64) error
+	close() error
+}
+
+// fileStore is the default store: a WAL file plus a snapshot file, both
+// living in the same directory. record() calls append from whichever
+// shard's goroutine happens to be running, while save/prune run from
+// maybeCheckpoint on (potentially) yet another goroutine, so every method
+// that touches wal or appendsSinceSync serializes through mu - without
+// it, a checkpoint's truncateWAL (which closes and replaces fs.wal) can
+// run concurrently with another goroutine's append, and a WAL entry
+// appended between snapshotForStore's copy and save's truncate would be
+// silently dropped from both the snapshot and the now-empty WAL.
+type fileStore struct {
+	walPath      string
+	snapshotPath string
+
+	mu     sync.Mutex
+	wal    *os.File
+	policy fsyncPolicy
+
+	appendsSinceSync int
+}
+
+// withFsyncPolicy sets the fileStore's fsync policy. The default, applied
+// by newFileStore when this option is omitted, is fsyncAlways.
+func withFsyncPolicy(policy fsyncPolicy) func(*fileStore) {
+	return func(fs *fileStore) {
+		fs.policy = policy
+	}
+}
+
+// newFileStore opens (creating if necessary) a WAL and snapshot file pair
+// inside dir.
+func newFileStore(dir string, options ...func(*fileStore)) (*fileStore, error) {
+	wal, err := os.OpenFile(filepath.Join(dir, "metrics.wal"), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening WAL: %w", err)
+	}
+
+	fs := &fileStore{
+		walPath:      filepath.Join(dir, "metrics.wal"),
+		snapshotPath: filepath.Join(dir, "metrics.snapshot"),
+		wal:          wal,
+		policy:       fsyncAlways,
+	}
+
+	for _, option := range options {
+		option(fs)
+	}
+
+	return fs, nil
+}
+
+// append writes entry to the WAL, fsyncing according to fs.policy.
+func (fs *fileStore) append(entry walEntry) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.appendLocked(entry)
+}
+
+// appendLocked is append's body, factored out so prune can append kept
+// entries without recursively taking mu.
+func (fs *fileStore) appendLocked(entry walEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshaling WAL entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	if _, err := fs.wal.Write(line); err != nil {
+		return fmt.Errorf("writing WAL entry: %w", err)
+	}
+
+	fs.appendsSinceSync++
+	switch fs.policy {
+	case fsyncAlways:
+		return fs.wal.Sync()
+	case fsyncInterval:
+		if fs.appendsSinceSync >= fsyncEvery {
+			fs.appendsSinceSync = 0
+			return fs.wal.Sync()
+		}
+	case fsyncNever:
+		// Leave fsyncing to the OS.
+	}
+
+	return nil
+}
+
+// load rebuilds the bucket map from the last snapshot plus every WAL
+// entry appended since, so a caller sees exactly the state it had before
+// a restart.
+func (fs *fileStore) load() (map[int64]map[string]*stat, error) {
+	minutes := make(map[int64]map[string]*stat)
+
+	if snap, err := os.ReadFile(fs.snapshotPath); err == nil {
+		if err := json.Unmarshal(snap, &minutes); err != nil {
+			return nil, fmt.Errorf("unmarshaling snapshot: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading snapshot: %w", err)
+	}
+
+	wal, err := os.Open(fs.walPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return minutes, nil
+		}
+		return nil, fmt.Errorf("opening WAL for replay: %w", err)
+	}
+	defer wal.Close()
+
+	scanner := bufio.NewScanner(wal)
+	for scanner.Scan() {
+		var entry walEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("unmarshaling WAL entry: %w", err)
+		}
+		if minutes[entry.Bucket] == nil {
+			minutes[entry.Bucket] = make(map[string]*stat)
+		}
+		if minutes[entry.Bucket][entry.Key] == nil {
+			minutes[entry.Bucket][entry.Key] = newStat()
+		}
+		minutes[entry.Bucket][entry.Key].add(entry.Value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanning WAL: %w", err)
+	}
+
+	return minutes, nil
+}
+
+// save checkpoints the full bucket map to the snapshot file and truncates
+// the WAL, since every entry in it is now reflected in the snapshot.
+// buildSnapshot is called under mu, so the snapshot it returns and the
+// WAL truncation below happen as one critical section with respect to
+// append: a concurrent record() either completes its append before
+// buildSnapshot runs (and so is captured in the snapshot), or blocks on
+// mu until after the truncate and lands in the fresh WAL - never in the
+// gap where it would be wiped by the truncate yet missing from the
+// snapshot.
+func (fs *fileStore) save(buildSnapshot func() map[int64]map[string]*stat) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	minutes := buildSnapshot()
+
+	tmp := fs.snapshotPath + ".tmp"
+	data, err := json.Marshal(minutes)
+	if err != nil {
+		return fmt.Errorf("marshaling snapshot: %w", err)
+	}
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, fs.snapshotPath); err != nil {
+		return fmt.Errorf("installing snapshot: %w", err)
+	}
+
+	return fs.truncateWAL()
+}
+
+// prune drops WAL entries for buckets older than oldestBucket, keeping the
+// log bounded even between snapshots. Locked for the same reason as
+// save: it reads, truncates, and rewrites the WAL, and none of that can
+// safely interleave with a concurrent append.
+func (fs *fileStore) prune(oldestBucket int64) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	wal, err := os.Open(fs.walPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("opening WAL for pruning: %w", err)
+	}
+
+	var kept []walEntry
+	scanner := bufio.NewScanner(wal)
+	for scanner.Scan() {
+		var entry walEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			wal.Close()
+			return fmt.Errorf("unmarshaling WAL entry: %w", err)
+		}
+		if entry.Bucket >= oldestBucket {
+			kept = append(kept, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		wal.Close()
+		return fmt.Errorf("scanning WAL: %w", err)
+	}
+	wal.Close()
+
+	if err := fs.truncateWAL(); err != nil {
+		return err
+	}
+	for _, entry := range kept {
+		if err := fs.appendLocked(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// truncateWAL resets the WAL file to empty, reopening it for append.
+func (fs *fileStore) truncateWAL() error {
+	if err := fs.wal.Close(); err != nil {
+		return fmt.Errorf("closing WAL: %w", err)
+	}
+
+	wal, err := os.OpenFile(fs.walPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("truncating WAL: %w", err)
+	}
+	fs.wal = wal
+	fs.appendsSinceSync = 0
+
+	return nil
+}
+
+func (fs *fileStore) close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.wal.Close()
+}